@@ -2,9 +2,11 @@
 package api
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,6 +20,33 @@ const (
 	TopicWriteValueResp       = "/_CLIENTS/ZWAVE_GATEWAY/api/writeValue"
 	TopicNodeValueUpdateEvent = "/_EVENTS/+/node/node_value_updated"
 
+	// Node lifecycle events, published under the node's driver instance.
+	TopicNodeAddedEvent         = "/_EVENTS/+/node/node_added"
+	TopicNodeRemovedEvent       = "/_EVENTS/+/node/node_removed"
+	TopicNodeReadyEvent         = "/_EVENTS/+/node/node_ready"
+	TopicNodeStatusChangedEvent = "/_EVENTS/+/node/node_status_changed"
+
+	// Names of the remaining documented custom APIs, for use with
+	// APITopic.
+	// https://github.com/OpenZWave/Zwave2Mqtt#custom-apis
+	APINameStartInclusion      = "startInclusion"
+	APINameStopInclusion       = "stopInclusion"
+	APINameStartExclusion      = "startExclusion"
+	APINameStopExclusion       = "stopExclusion"
+	APINameHealNode            = "healNode"
+	APINameBeginHealingNetwork = "beginHealingNetwork"
+	APINameStopHealingNetwork  = "stopHealingNetwork"
+	APINameRefreshInfo         = "refreshInfo"
+	APINameSetNodeName         = "setNodeName"
+	APINameSetNodeLocation     = "setNodeLocation"
+	APINameSendCommand         = "sendCommand"
+	APINamePingNode            = "pingNode"
+	APINameBeginFirmwareUpdate = "beginFirmwareUpdate"
+	APINameAbortFirmwareUpdate = "abortFirmwareUpdate"
+	APINameCreateScene         = "_createScene"
+	APINameAddSceneValue       = "_addSceneValue"
+	APINameActivateScene       = "_activateScene"
+
 	// Various Value "types".
 	// https://github.com/zwave-js/node-zwave-js/blob/fa1bbf556860665d396d4801a412b45e2bb72087/packages/core/src/values/Metadata.ts#L29-L38
 	TypeNumber     = "number"
@@ -108,55 +137,170 @@ type Value struct {
 // Value uses the Value's Type to decode the value field
 // into the proper Go type, which is then returned.
 func (v Value) Value() (interface{}, error) {
-	switch v.Type {
+	return decodeValue(v.Type, v.RawValue)
+}
+
+// decodeValue decodes a raw JSON value payload according to t, one of the
+// Type* constants. It is shared by Value.Value and NodeValueUpdate.Previous
+// so both the current and previous value of an update decode identically.
+func decodeValue(t string, raw json.RawMessage) (interface{}, error) {
+	switch t {
 	case TypeNumber:
 		var tv int
-		if len(v.RawValue) > 0 {
-			if err := json.Unmarshal(v.RawValue, &tv); err != nil {
-				return nil, fmt.Errorf("unable to parse value of type %q: %w", v.Type, err)
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &tv); err != nil {
+				return nil, fmt.Errorf("unable to parse value of type %q: %w", t, err)
 			}
 		}
 		return tv, nil
 	case TypeBool:
 		var tv bool
-		if len(v.RawValue) > 0 {
-			if err := json.Unmarshal(v.RawValue, &tv); err != nil {
-				return nil, fmt.Errorf("unable to parse value of type %q (%q): %w", string(v.RawValue), v.Type, err)
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &tv); err != nil {
+				return nil, fmt.Errorf("unable to parse value of type %q (%q): %w", t, string(raw), err)
 			}
 		}
 		return tv, nil
-	case TypeString, TypeColor:
+	case TypeString:
 		var tv string
-		if err := json.Unmarshal(v.RawValue, &tv); err != nil {
-			return nil, fmt.Errorf("unable to parse value of type %q: %w", v.Type, err)
+		if err := json.Unmarshal(raw, &tv); err != nil {
+			return nil, fmt.Errorf("unable to parse value of type %q: %w", t, err)
 		}
 		return tv, nil
+	case TypeColor:
+		c, err := decodeColor(raw)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse value of type %q: %w", t, err)
+		}
+		return c, nil
 	case TypeDuration:
-		var unit struct {
-			Unit string `json:"unit"`
+		var dur struct {
+			Value int    `json:"value"`
+			Unit  string `json:"unit"`
 		}
-		if err := json.Unmarshal(v.RawValue, &unit); err != nil {
-			return nil, fmt.Errorf("unable to parse value of type %q: %w", v.Type, err)
+		if err := json.Unmarshal(raw, &dur); err != nil {
+			return nil, fmt.Errorf("unable to parse value of type %q: %w", t, err)
 		}
 		// https://github.com/zwave-js/node-zwave-js/blob/0a7bdb15dd50ecc5aa146c12c20b360320b9e169/packages/core/src/values/Duration.ts#L5
-		switch unit.Unit {
+		switch dur.Unit {
 		case DurationSeconds:
-			return time.Second, nil
+			return time.Duration(dur.Value) * time.Second, nil
 		case DurationMinutes:
-			return time.Minute, nil
+			return time.Duration(dur.Value) * time.Minute, nil
 		default:
 			return time.Duration(0), nil
 		}
+	case TypeListNumber:
+		var tv []int
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &tv); err != nil {
+				return nil, fmt.Errorf("unable to parse value of type %q: %w", t, err)
+			}
+		}
+		return tv, nil
+	case TypeListBool:
+		var tv []bool
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &tv); err != nil {
+				return nil, fmt.Errorf("unable to parse value of type %q: %w", t, err)
+			}
+		}
+		return tv, nil
+	case TypeListString:
+		var tv []string
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &tv); err != nil {
+				return nil, fmt.Errorf("unable to parse value of type %q: %w", t, err)
+			}
+		}
+		return tv, nil
 	case TypeAny:
-		return v.RawValue, nil
+		return raw, nil
 	default:
-		return nil, fmt.Errorf("unknown value type %q", v.Type)
+		return nil, fmt.Errorf("unknown value type %q", t)
+	}
+}
+
+// Color is a decoded TypeColor value. zwavejs2mqtt represents multi-channel
+// colors as an object keyed by channel name, or occasionally as a
+// "#rrggbb" string; decodeColor accepts both.
+type Color struct {
+	R, G, B uint8
+	W, CW   uint8 // warm white, cold white
+}
+
+func decodeColor(raw json.RawMessage) (Color, error) {
+	if len(raw) == 0 {
+		return Color{}, nil
+	}
+
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return Color{}, err
+		}
+		return parseHexColor(s)
+	}
+
+	var obj struct {
+		Red       uint8 `json:"red"`
+		Green     uint8 `json:"green"`
+		Blue      uint8 `json:"blue"`
+		WarmWhite uint8 `json:"warmWhite"`
+		ColdWhite uint8 `json:"coldWhite"`
+	}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return Color{}, err
+	}
+	return Color{R: obj.Red, G: obj.Green, B: obj.Blue, W: obj.WarmWhite, CW: obj.ColdWhite}, nil
+}
+
+func parseHexColor(s string) (Color, error) {
+	s = strings.TrimPrefix(s, "#")
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 3 {
+		return Color{}, fmt.Errorf("invalid color string %q", s)
+	}
+	return Color{R: b[0], G: b[1], B: b[2]}, nil
+}
+
+// wireValue returns the object representation zwavejs2mqtt expects when
+// writing a TypeColor value.
+func (c Color) wireValue() interface{} {
+	return struct {
+		Red       uint8 `json:"red"`
+		Green     uint8 `json:"green"`
+		Blue      uint8 `json:"blue"`
+		WarmWhite uint8 `json:"warmWhite"`
+		ColdWhite uint8 `json:"coldWhite"`
+	}{c.R, c.G, c.B, c.W, c.CW}
+}
+
+// durationWireValue returns the {value, unit} object representation
+// zwavejs2mqtt expects when writing a TypeDuration value, preferring
+// whole minutes when possible.
+func durationWireValue(d time.Duration) interface{} {
+	v := struct {
+		Value int    `json:"value"`
+		Unit  string `json:"unit"`
+	}{}
+	if d >= time.Minute && d%time.Minute == 0 {
+		v.Value, v.Unit = int(d/time.Minute), DurationMinutes
+	} else {
+		v.Value, v.Unit = int(d/time.Second), DurationSeconds
 	}
+	return v
 }
 
 func (v Value) WriteValue(value interface{}) APIArgs {
 	// TODO: It would be possible to do limited type checking
 	// on value based on v.Type.
+	switch tv := value.(type) {
+	case Color:
+		value = tv.wireValue()
+	case time.Duration:
+		value = durationWireValue(tv)
+	}
 	return APIArgs{
 		Args: []interface{}{
 			ValueID{
@@ -185,17 +329,42 @@ func (s *StringInt) UnmarshalJSON(b []byte) error {
 	return json.Unmarshal(b, &s.i)
 }
 
-func (s *StringInt) String() string {
+func (s StringInt) String() string {
 	if s.s != "" {
 		return s.s
 	}
 	return strconv.Itoa(s.i)
 }
 
+// MarshalJSON encodes the value as a JSON string, regardless of which
+// form (string or number) it was originally decoded from. This keeps
+// re-encoding (e.g. a Store writing back a full node snapshot) lossless
+// rather than producing "{}", which UnmarshalJSON can't parse back.
+func (s StringInt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
 type APIArgs struct {
 	Args []interface{} `json:"args"`
 }
 
+// APITopic returns the request/response topic pair for the custom API
+// named name, following the <mqtt_prefix>/_CLIENTS/ZWAVE_GATEWAY/api/<name>
+// (/set) convention documented at
+// https://github.com/OpenZWave/Zwave2Mqtt#custom-apis.
+func APITopic(name string) (req, resp string) {
+	resp = "/_CLIENTS/ZWAVE_GATEWAY/api/" + name
+	return resp + "/set", resp
+}
+
+// APIResult is the generic response shape of the custom APIs that don't
+// have a more specific response type, such as WriteValueResp.
+type APIResult struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Result  json.RawMessage `json:"result"`
+}
+
 type ValueID struct {
 	NodeID       int    `json:"nodeId"`
 	CommandClass int    `json:"commandClass"`
@@ -236,6 +405,20 @@ func (w *WriteValueResp) UnmarshalJSON(b []byte) error {
 
 type NodeValueUpdate struct {
 	Value
+
+	// PrevValue holds the raw "prevValue" field of the update payload, if
+	// any. Decode it with Previous.
+	PrevValue json.RawMessage
+}
+
+// Previous decodes PrevValue using the same Type as the current Value. It
+// returns (nil, nil) if the update carried no previous value, e.g. the
+// first update for a property.
+func (n NodeValueUpdate) Previous() (interface{}, error) {
+	if len(n.PrevValue) == 0 {
+		return nil, nil
+	}
+	return decodeValue(n.Value.Type, n.PrevValue)
 }
 
 func (n *NodeValueUpdate) UnmarshalJSON(b []byte) error {
@@ -267,10 +450,11 @@ func (n *NodeValueUpdate) UnmarshalJSON(b []byte) error {
 	   }
 	*/
 	var update struct {
-		CommandClass int       `json:"commandClass"`
-		Endpoint     int       `json:"endpoint"`
-		Property     StringInt `json:"property"`
-		PropertyKey  string    `json:"propertyKey"`
+		CommandClass int             `json:"commandClass"`
+		Endpoint     int             `json:"endpoint"`
+		Property     StringInt       `json:"property"`
+		PropertyKey  string          `json:"propertyKey"`
+		PrevValue    json.RawMessage `json:"prevValue"`
 	}
 	if err := json.Unmarshal(obj.Data[1], &update); err != nil {
 		return err
@@ -286,6 +470,7 @@ func (n *NodeValueUpdate) UnmarshalJSON(b []byte) error {
 	}
 
 	n.Value = value
+	n.PrevValue = update.PrevValue
 
 	return nil
 }
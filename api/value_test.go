@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValue_Value_Types(t *testing.T) {
+	fd, err := os.Open("testdata/values.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	var values map[string]Value
+	if err := json.NewDecoder(fd).Decode(&values); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want interface{}
+	}{
+		{"duration_seconds", 45 * time.Second},
+		{"duration_minutes", 2 * time.Minute},
+		{"color_object", Color{R: 10, G: 20, B: 30, W: 1, CW: 2}},
+		{"color_hex", Color{R: 0xff, G: 0x00, B: 0xaa}},
+		{"list_number", []int{1, 2, 3}},
+		{"list_bool", []bool{true, false, true}},
+		{"list_string", []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, ok := values[tt.name]
+			if !ok {
+				t.Fatalf("testdata/values.json missing entry %q", tt.name)
+			}
+
+			got, err := v.Value()
+			if err != nil {
+				t.Fatalf("Value() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Value() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValue_WriteValue_Color(t *testing.T) {
+	v := Value{NodeID: 4, CommandClass: 51, Endpoint: 0}
+	v.Property.s = "targetColor"
+
+	args := v.WriteValue(Color{R: 1, G: 2, B: 3, W: 4, CW: 5})
+
+	b, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"args":[{"nodeId":4,"commandClass":51,"endpoint":0,"property":"targetColor"},{"red":1,"green":2,"blue":3,"warmWhite":4,"coldWhite":5}]}`
+	if string(b) != want {
+		t.Errorf("WriteValue() = %s, want %s", b, want)
+	}
+}
+
+func TestNodeValueUpdate_Previous(t *testing.T) {
+	const payload = `{"data": [{
+		"id": 1,
+		"name": "light1",
+		"values": {
+			"38-0-currentValue": {
+				"id": "38-0-currentValue",
+				"nodeId": 1,
+				"commandClass": 38,
+				"endpoint": 0,
+				"property": "currentValue",
+				"type": "number",
+				"value": 2
+			}
+		}
+	}, {
+		"commandClass": 38,
+		"endpoint": 0,
+		"property": "currentValue",
+		"newValue": 2,
+		"prevValue": 1
+	}]}`
+
+	var update NodeValueUpdate
+	if err := json.Unmarshal([]byte(payload), &update); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := update.Previous()
+	if err != nil {
+		t.Fatalf("Previous() error: %v", err)
+	}
+	if got != 1 {
+		t.Errorf("Previous() = %#v, want 1", got)
+	}
+}
+
+func TestNodeValueUpdate_Previous_None(t *testing.T) {
+	const payload = `{"data": [{
+		"id": 1,
+		"name": "light1",
+		"values": {
+			"38-0-currentValue": {
+				"id": "38-0-currentValue",
+				"nodeId": 1,
+				"commandClass": 38,
+				"endpoint": 0,
+				"property": "currentValue",
+				"type": "number",
+				"value": 2
+			}
+		}
+	}, {
+		"commandClass": 38,
+		"endpoint": 0,
+		"property": "currentValue",
+		"newValue": 2
+	}]}`
+
+	var update NodeValueUpdate
+	if err := json.Unmarshal([]byte(payload), &update); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := update.Previous()
+	if err != nil {
+		t.Fatalf("Previous() error: %v", err)
+	}
+	if got != nil {
+		t.Errorf("Previous() = %#v, want nil", got)
+	}
+}
+
+func TestValue_WriteValue_Duration(t *testing.T) {
+	v := Value{NodeID: 4, CommandClass: 38, Endpoint: 0}
+	v.Property.s = "duration"
+
+	args := v.WriteValue(90 * time.Second)
+
+	b, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"args":[{"nodeId":4,"commandClass":38,"endpoint":0,"property":"duration"},{"value":90,"unit":"seconds"}]}`
+	if string(b) != want {
+		t.Errorf("WriteValue() = %s, want %s", b, want)
+	}
+}
@@ -0,0 +1,407 @@
+package z2m
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/awilliams/z2m/api"
+)
+
+// apiNames lists the custom APIs handled generically via callAPI/callAPIResp,
+// i.e. everything besides getNodes and writeValue, which have their own
+// strongly typed request/response handling.
+var apiNames = []string{
+	api.APINameStartInclusion,
+	api.APINameStopInclusion,
+	api.APINameStartExclusion,
+	api.APINameStopExclusion,
+	api.APINameHealNode,
+	api.APINameBeginHealingNetwork,
+	api.APINameStopHealingNetwork,
+	api.APINameRefreshInfo,
+	api.APINameSetNodeName,
+	api.APINameSetNodeLocation,
+	api.APINameSendCommand,
+	api.APINamePingNode,
+	api.APINameBeginFirmwareUpdate,
+	api.APINameAbortFirmwareUpdate,
+	api.APINameCreateScene,
+	api.APINameAddSceneValue,
+	api.APINameActivateScene,
+}
+
+// callAPI publishes a request to the custom API named name and blocks
+// until its response arrives, ctx is done, or the Broker is Closed.
+// zwavejs2mqtt's custom API responses carry no correlation ID, so only
+// one call to a given API name may be in flight at a time; concurrent
+// callers block on the name's semaphore (see apiSemFor) until the one
+// ahead of them completes,
+// instead of clobbering each other's entry in apiCalls.
+func (b *Broker) callAPI(ctx context.Context, name string, args []interface{}) (api.APIResult, error) {
+	sem := b.apiSemFor(name)
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return api.APIResult{}, ctx.Err()
+	case <-b.closed:
+		return api.APIResult{}, ErrClosed
+	}
+	defer func() { <-sem }()
+
+	req, _ := api.APITopic(name)
+
+	payload, err := json.Marshal(api.APIArgs{Args: args})
+	if err != nil {
+		return api.APIResult{}, err
+	}
+
+	wait := make(chan api.APIResult, 1)
+	b.mu.Lock()
+	b.apiCalls[name] = wait
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.apiCalls, name)
+		b.mu.Unlock()
+	}()
+
+	if err := b.p.Publish(req, payload); err != nil {
+		return api.APIResult{}, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return api.APIResult{}, ctx.Err()
+	case <-b.closed:
+		return api.APIResult{}, ErrClosed
+	case resp := <-wait:
+		if !resp.Success {
+			return resp, fmt.Errorf("%s: %s", name, resp.Message)
+		}
+		return resp, nil
+	}
+}
+
+// apiSemFor returns the capacity-1 channel used to serialize calls to the
+// given API name, creating it on first use.
+func (b *Broker) apiSemFor(name string) chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sem, ok := b.apiSems[name]
+	if !ok {
+		sem = make(chan struct{}, 1)
+		b.apiSems[name] = sem
+	}
+	return sem
+}
+
+func (b *Broker) handleAPIResp(name string) func([]byte) error {
+	return func(payload []byte) error {
+		var resp api.APIResult
+		if err := json.Unmarshal(payload, &resp); err != nil {
+			return err
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		wait, ok := b.apiCalls[name]
+		if !ok {
+			return nil
+		}
+
+		select {
+		case wait <- resp:
+		default:
+		}
+		return nil
+	}
+}
+
+// nodeID resolves a node's friendly Name to the ID zwavejs2mqtt expects
+// in its custom API requests.
+func (b *Broker) nodeID(nodeName string) (int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	n, ok := b.nodesByName[nodeName]
+	if !ok {
+		return 0, fmt.Errorf("node %q not found", nodeName)
+	}
+	return n.ID, nil
+}
+
+// valueID resolves a node/property pair to the api.ValueID zwavejs2mqtt
+// uses to address a specific value.
+func (b *Broker) valueID(nodeName, property string) (api.ValueID, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	n, ok := b.nodesByName[nodeName]
+	if !ok {
+		return api.ValueID{}, fmt.Errorf("node %q not found", nodeName)
+	}
+	v, ok := n.valuesByProperty[property]
+	if !ok {
+		return api.ValueID{}, fmt.Errorf("node %q has no attribute with label %q", nodeName, property)
+	}
+	return api.ValueID{
+		NodeID:       v.NodeID,
+		CommandClass: v.CommandClass,
+		Endpoint:     v.Endpoint,
+		Property:     v.Property.String(),
+	}, nil
+}
+
+// StartInclusion puts the Z-Wave controller into inclusion mode.
+func (b *Broker) StartInclusion(ctx context.Context, includeNonSecure bool) error {
+	_, err := b.callAPI(ctx, api.APINameStartInclusion, []interface{}{includeNonSecure})
+	return err
+}
+
+// StopInclusion cancels a prior StartInclusion.
+func (b *Broker) StopInclusion(ctx context.Context) error {
+	_, err := b.callAPI(ctx, api.APINameStopInclusion, nil)
+	return err
+}
+
+// StartExclusion puts the Z-Wave controller into exclusion mode.
+func (b *Broker) StartExclusion(ctx context.Context) error {
+	_, err := b.callAPI(ctx, api.APINameStartExclusion, nil)
+	return err
+}
+
+// StopExclusion cancels a prior StartExclusion.
+func (b *Broker) StopExclusion(ctx context.Context) error {
+	_, err := b.callAPI(ctx, api.APINameStopExclusion, nil)
+	return err
+}
+
+// HealNode re-establishes the optimal network route to the given node.
+func (b *Broker) HealNode(ctx context.Context, nodeName string) error {
+	id, err := b.nodeID(nodeName)
+	if err != nil {
+		return err
+	}
+	_, err = b.callAPI(ctx, api.APINameHealNode, []interface{}{id})
+	return err
+}
+
+// BeginHealingNetwork heals the routes of every node in the network.
+func (b *Broker) BeginHealingNetwork(ctx context.Context) error {
+	_, err := b.callAPI(ctx, api.APINameBeginHealingNetwork, nil)
+	return err
+}
+
+// StopHealingNetwork cancels a prior BeginHealingNetwork.
+func (b *Broker) StopHealingNetwork(ctx context.Context) error {
+	_, err := b.callAPI(ctx, api.APINameStopHealingNetwork, nil)
+	return err
+}
+
+// RefreshInfo re-interviews the given node.
+func (b *Broker) RefreshInfo(ctx context.Context, nodeName string) error {
+	id, err := b.nodeID(nodeName)
+	if err != nil {
+		return err
+	}
+	_, err = b.callAPI(ctx, api.APINameRefreshInfo, []interface{}{id})
+	return err
+}
+
+// SetNodeName renames the given node within zwavejs2mqtt.
+func (b *Broker) SetNodeName(ctx context.Context, nodeName, newName string) error {
+	id, err := b.nodeID(nodeName)
+	if err != nil {
+		return err
+	}
+	_, err = b.callAPI(ctx, api.APINameSetNodeName, []interface{}{id, newName})
+	return err
+}
+
+// SetNodeLocation sets the given node's location within zwavejs2mqtt.
+func (b *Broker) SetNodeLocation(ctx context.Context, nodeName, location string) error {
+	id, err := b.nodeID(nodeName)
+	if err != nil {
+		return err
+	}
+	_, err = b.callAPI(ctx, api.APINameSetNodeLocation, []interface{}{id, location})
+	return err
+}
+
+// SendCommand issues a raw Z-Wave command against a node/command class,
+// for functionality not exposed as an api.Value.
+func (b *Broker) SendCommand(ctx context.Context, nodeName string, commandClass, endpoint int, command string, args ...interface{}) (json.RawMessage, error) {
+	id, err := b.nodeID(nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	req := map[string]interface{}{
+		"nodeId":       id,
+		"commandClass": commandClass,
+		"endpoint":     endpoint,
+		"command":      command,
+		"args":         args,
+	}
+
+	resp, err := b.callAPI(ctx, api.APINameSendCommand, []interface{}{req})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Result, nil
+}
+
+// PingNode pings the given node and reports whether it responded.
+func (b *Broker) PingNode(ctx context.Context, nodeName string) (bool, error) {
+	id, err := b.nodeID(nodeName)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := b.callAPI(ctx, api.APINamePingNode, []interface{}{id})
+	if err != nil {
+		return false, err
+	}
+
+	var responded bool
+	if len(resp.Result) > 0 {
+		if err := json.Unmarshal(resp.Result, &responded); err != nil {
+			return false, fmt.Errorf("unable to parse pingNode result: %w", err)
+		}
+	}
+	return responded, nil
+}
+
+// BeginFirmwareUpdate starts a firmware OTA update on the given node.
+func (b *Broker) BeginFirmwareUpdate(ctx context.Context, nodeName, fileName string, data []byte) error {
+	id, err := b.nodeID(nodeName)
+	if err != nil {
+		return err
+	}
+	_, err = b.callAPI(ctx, api.APINameBeginFirmwareUpdate, []interface{}{id, fileName, data})
+	return err
+}
+
+// AbortFirmwareUpdate cancels a firmware update in progress on the given node.
+func (b *Broker) AbortFirmwareUpdate(ctx context.Context, nodeName string) error {
+	id, err := b.nodeID(nodeName)
+	if err != nil {
+		return err
+	}
+	_, err = b.callAPI(ctx, api.APINameAbortFirmwareUpdate, []interface{}{id})
+	return err
+}
+
+// CreateScene creates a new scene named label and returns its ID.
+func (b *Broker) CreateScene(ctx context.Context, label string) (int, error) {
+	resp, err := b.callAPI(ctx, api.APINameCreateScene, []interface{}{label})
+	if err != nil {
+		return 0, err
+	}
+
+	var sceneID int
+	if err := json.Unmarshal(resp.Result, &sceneID); err != nil {
+		return 0, fmt.Errorf("unable to parse _createScene result: %w", err)
+	}
+	return sceneID, nil
+}
+
+// AddSceneValue adds the given node/property's target value to a scene
+// previously created with CreateScene.
+func (b *Broker) AddSceneValue(ctx context.Context, sceneID int, nodeName, property string, value interface{}) error {
+	vid, err := b.valueID(nodeName, property)
+	if err != nil {
+		return err
+	}
+	_, err = b.callAPI(ctx, api.APINameAddSceneValue, []interface{}{sceneID, vid, value})
+	return err
+}
+
+// ActivateScene triggers every value set on a scene previously created
+// with CreateScene.
+func (b *Broker) ActivateScene(ctx context.Context, sceneID int) error {
+	_, err := b.callAPI(ctx, api.APINameActivateScene, []interface{}{sceneID})
+	return err
+}
+
+// NodeEventType identifies which node lifecycle event a NodeEvent
+// describes.
+type NodeEventType string
+
+const (
+	NodeAdded         NodeEventType = "node_added"
+	NodeRemoved       NodeEventType = "node_removed"
+	NodeReady         NodeEventType = "node_ready"
+	NodeStatusChanged NodeEventType = "node_status_changed"
+)
+
+// NodeEvent describes a node inclusion/exclusion/status lifecycle event.
+// Node is decoded on a best-effort basis: node_added/node_removed/node_ready
+// carry a full api.Node, while node_status_changed carries only a subset
+// of its fields (at least ID and Status).
+type NodeEvent struct {
+	Type      NodeEventType
+	Node      api.Node
+	Timestamp time.Time
+}
+
+// WatchNodeLifecycle subscribes ch to every node inclusion, exclusion,
+// and status change, so callers can react without polling GetNodes. The
+// returned func unsubscribes ch.
+func (b *Broker) WatchNodeLifecycle(ch chan<- NodeEvent) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nodeWatchers[ch] = struct{}{}
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.nodeWatchers, ch)
+	}
+}
+
+func (b *Broker) handleNodeLifecycleEvent(t NodeEventType) func([]byte) error {
+	return func(payload []byte) error {
+		var obj struct {
+			Data []json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(payload, &obj); err != nil {
+			return err
+		}
+		if len(obj.Data) == 0 {
+			return fmt.Errorf("%s event had no data", t)
+		}
+
+		var n api.Node
+		if err := json.Unmarshal(obj.Data[0], &n); err != nil {
+			return fmt.Errorf("unable to parse %s event: %w", t, err)
+		}
+
+		event := NodeEvent{Type: t, Node: n, Timestamp: time.Now()}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		switch t {
+		case NodeAdded, NodeReady:
+			b.addNodeLocked(n)
+		case NodeRemoved:
+			delete(b.nodesByID, n.ID)
+			delete(b.nodesByName, n.Name)
+		}
+
+		for c := range b.nodeWatchers {
+			select {
+			case c <- event:
+			default:
+			}
+		}
+
+		return nil
+	}
+}
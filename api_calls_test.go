@@ -0,0 +1,181 @@
+package z2m
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/awilliams/z2m/api"
+)
+
+// TestBrokerCallAPISerializesSameName verifies that two concurrent calls
+// to the same API name each get their own response back, rather than one
+// clobbering the other's entry in apiCalls.
+func TestBrokerCallAPISerializesSameName(t *testing.T) {
+	const name = api.APINameHealNode
+
+	var b *Broker
+	pub := PublisherFunc(func(topic string, payload []byte) error {
+		var req api.APIArgs
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return err
+		}
+		id := fmt.Sprint(req.Args[0])
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			resp, _ := json.Marshal(api.APIResult{Success: true, Message: id})
+			b.handleAPIResp(name)(resp)
+		}()
+		return nil
+	})
+	b = NewBroker(pub)
+
+	results := make(chan string, 2)
+	call := func(id int) {
+		resp, err := b.callAPI(context.Background(), name, []interface{}{id})
+		if err != nil {
+			results <- "err:" + err.Error()
+			return
+		}
+		results <- resp.Message
+	}
+
+	go call(1)
+	time.Sleep(2 * time.Millisecond) // ensure the first call registers before the second starts
+	go call(2)
+
+	got := make(map[string]bool, 2)
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-results:
+			got[r] = true
+		case <-time.After(time.Second):
+			t.Fatal("callAPI calls did not complete")
+		}
+	}
+	if !got["1"] || !got["2"] {
+		t.Fatalf("expected a response for each call, got %v", got)
+	}
+}
+
+// apiRespondingPublisher returns a Publisher that answers every API request
+// published to it with result, via the Broker's own response handler.
+func apiRespondingPublisher(b **Broker, name string, result json.RawMessage) Publisher {
+	return PublisherFunc(func(topic string, payload []byte) error {
+		go func() {
+			resp, _ := json.Marshal(api.APIResult{Success: true, Result: result})
+			(*b).handleAPIResp(name)(resp)
+		}()
+		return nil
+	})
+}
+
+// TestBrokerPingNode verifies PingNode decodes the custom API's bool result.
+func TestBrokerPingNode(t *testing.T) {
+	for _, want := range []bool{true, false} {
+		t.Run(fmt.Sprint(want), func(t *testing.T) {
+			var b *Broker
+			result, _ := json.Marshal(want)
+			b = NewBroker(apiRespondingPublisher(&b, api.APINamePingNode, result))
+			if err := b.handleGetNodesResp([]byte(getNodesRespFixture)); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := b.PingNode(context.Background(), "light1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("PingNode() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// TestBrokerCreateScene verifies CreateScene decodes the custom API's int
+// scene ID result.
+func TestBrokerCreateScene(t *testing.T) {
+	var b *Broker
+	result, _ := json.Marshal(7)
+	b = NewBroker(apiRespondingPublisher(&b, api.APINameCreateScene, result))
+
+	got, err := b.CreateScene(context.Background(), "movie night")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 7 {
+		t.Errorf("CreateScene() = %d, want 7", got)
+	}
+}
+
+// TestBrokerHandleNodeLifecycleEvent verifies node_added adds the event's
+// node to the Broker's state and node_removed removes it.
+func TestBrokerHandleNodeLifecycleEvent(t *testing.T) {
+	b := NewBroker(PublisherFunc(func(topic string, payload []byte) error { return nil }))
+
+	const nodeAddedPayload = `{"data": [{
+		"id": 2,
+		"name": "light2",
+		"values": {}
+	}]}`
+	if err := b.handleNodeLifecycleEvent(NodeAdded)([]byte(nodeAddedPayload)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.nodeID("light2"); err != nil {
+		t.Fatalf("node not added after node_added event: %v", err)
+	}
+
+	const nodeRemovedPayload = `{"data": [{
+		"id": 2,
+		"name": "light2",
+		"values": {}
+	}]}`
+	if err := b.handleNodeLifecycleEvent(NodeRemoved)([]byte(nodeRemovedPayload)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.nodeID("light2"); err == nil {
+		t.Fatal("node still present after node_removed event")
+	}
+}
+
+// TestBrokerWatchNodeLifecycle verifies WatchNodeLifecycle delivers node
+// lifecycle events and its returned unsubscribe func stops delivery.
+func TestBrokerWatchNodeLifecycle(t *testing.T) {
+	b := NewBroker(PublisherFunc(func(topic string, payload []byte) error { return nil }))
+
+	ch := make(chan NodeEvent, 1)
+	unsubscribe := b.WatchNodeLifecycle(ch)
+
+	const nodeAddedPayload = `{"data": [{
+		"id": 2,
+		"name": "light2",
+		"values": {}
+	}]}`
+	if err := b.handleNodeLifecycleEvent(NodeAdded)([]byte(nodeAddedPayload)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-ch:
+		if event.Type != NodeAdded || event.Node.ID != 2 {
+			t.Fatalf("event = %+v, want Type NodeAdded, Node.ID 2", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("did not receive NodeEvent from WatchNodeLifecycle")
+	}
+
+	unsubscribe()
+
+	if err := b.handleNodeLifecycleEvent(NodeAdded)([]byte(nodeAddedPayload)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-ch:
+		t.Fatalf("received %+v after unsubscribe", event)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
@@ -10,20 +10,60 @@ import (
 	"fmt"
 	"path"
 	"sync"
+	"time"
 
 	"github.com/awilliams/z2m/api"
 )
 
+// ValueEvent carries a node/property value update along with the identity
+// of the node and property it belongs to, so a single channel can be used
+// to watch multiple values via WatchValues or WatchAll.
+type ValueEvent struct {
+	NodeName string
+	NodeID   int
+	Property string
+
+	Value    interface{}
+	Previous interface{}
+
+	Timestamp time.Time
+}
+
+// ErrClosed is returned by in-flight Broker calls (GetNodes, SetAttr) once
+// Close has been called.
+var ErrClosed = errors.New("z2m: broker closed")
+
+// Store persists the Broker's node/value state, so a restarted process
+// can make SetAttr/WatchValue available immediately instead of waiting
+// for a full GetNodes round-trip. Implementations are expected to be
+// safe for concurrent use.
+type Store interface {
+	// SaveNodes persists a full node snapshot, replacing any previously
+	// saved snapshot.
+	SaveNodes(nodes []api.Node) error
+	// LoadNodes returns the most recently saved node snapshot, or a nil
+	// slice if none has been saved yet.
+	LoadNodes() ([]api.Node, error)
+	// SaveValue persists a single value update against the snapshot
+	// passed to the most recent SaveNodes.
+	SaveValue(id api.ValueID, raw json.RawMessage, t time.Time) error
+}
+
 // NewBroker returns a Broker instance that uses
 // the given Publisher to publish messages to the zwavejs2mqtt
 // API.
 func NewBroker(publisher Publisher) *Broker {
 	return &Broker{
-		p:           publisher,
-		gotNodes:    make(chan error),
-		setAttr:     make(map[api.ValueID]chan<- error),
-		nodesByName: make(map[string]*Node),
-		nodesByID:   make(map[int]*Node),
+		p:            publisher,
+		gotNodes:     make(chan error),
+		setAttr:      make(map[api.ValueID]chan<- error),
+		apiCalls:     make(map[string]chan<- api.APIResult),
+		apiSems:      make(map[string]chan struct{}),
+		nodesByName:  make(map[string]*Node),
+		nodesByID:    make(map[int]*Node),
+		allWatchers:  make(map[chan<- ValueEvent]struct{}),
+		nodeWatchers: make(map[chan<- NodeEvent]struct{}),
+		closed:       make(chan struct{}),
 	}
 }
 
@@ -33,26 +73,118 @@ type Broker struct {
 
 	gotNodes chan error
 
-	mu      sync.RWMutex // Protects following
-	setAttr map[api.ValueID]chan<- error
+	mu       sync.RWMutex // Protects following
+	setAttr  map[api.ValueID]chan<- error
+	apiCalls map[string]chan<- api.APIResult // API name -> pending call
+	apiSems  map[string]chan struct{}        // API name -> call serialization semaphore
 
 	nodesByName map[string]*Node // Node.Name -> Node
 	nodesByID   map[int]*Node    // Node.ID -> Node
+
+	allWatchers  map[chan<- ValueEvent]struct{} // WatchAll subscribers
+	nodeWatchers map[chan<- NodeEvent]struct{}  // WatchNodeLifecycle subscribers
+
+	store Store
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// UseStore loads a previously saved node snapshot from s, making
+// SetAttr/WatchValue/Nodes available immediately instead of waiting for
+// GetNodes to complete, and write-through persists subsequent node
+// snapshots and value updates to s. It should be called once, before
+// GetNodes.
+func (b *Broker) UseStore(s Store) error {
+	nodes, err := s.LoadNodes()
+	if err != nil {
+		return fmt.Errorf("loading nodes from store: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.store = s
+	for _, n := range nodes {
+		b.addNodeLocked(n)
+	}
+	return nil
+}
+
+// Close makes all in-flight GetNodes and SetAttr calls return ErrClosed,
+// and closes any Watcher returned by WatchValueContext. It is safe to
+// call more than once.
+func (b *Broker) Close() error {
+	b.closeOnce.Do(func() { close(b.closed) })
+	return nil
 }
 
 // Subscriptions returns a map of MQTT topics that should be subscribed to
 // and their corresponding handler functions.
 func (b *Broker) Subscriptions(topicPrefix string) map[string]func(payload []byte) error {
-	return map[string]func([]byte) error{
+	subs := map[string]func([]byte) error{
 		path.Join(topicPrefix, api.TopicGetNodesResp):         b.handleGetNodesResp,
 		path.Join(topicPrefix, api.TopicWriteValueResp):       b.handleWriteValueResp,
 		path.Join(topicPrefix, api.TopicNodeValueUpdateEvent): b.handleNodeValueUpdated,
+
+		path.Join(topicPrefix, api.TopicNodeAddedEvent):         b.handleNodeLifecycleEvent(NodeAdded),
+		path.Join(topicPrefix, api.TopicNodeRemovedEvent):       b.handleNodeLifecycleEvent(NodeRemoved),
+		path.Join(topicPrefix, api.TopicNodeReadyEvent):         b.handleNodeLifecycleEvent(NodeReady),
+		path.Join(topicPrefix, api.TopicNodeStatusChangedEvent): b.handleNodeLifecycleEvent(NodeStatusChanged),
+	}
+
+	for _, name := range apiNames {
+		_, resp := api.APITopic(name)
+		subs[path.Join(topicPrefix, resp)] = b.handleAPIResp(name)
 	}
+
+	return subs
+}
+
+// Nodes returns the set of nodes known to the Broker. It is only
+// meaningful to call after GetNodes has completed successfully, or after
+// UseStore has loaded a saved snapshot.
+func (b *Broker) Nodes() []*Node {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	nodes := make([]*Node, 0, len(b.nodesByID))
+	for _, n := range b.nodesByID {
+		nodes = append(nodes, n)
+	}
+	return nodes
 }
 
 func (b *Broker) WatchValue(nodeName, property string, v chan<- interface{}) (func(), error) {
-	// TODO: Send nodeName and property along with value on channel. This
-	// would allow a single channel to be used for multiple watches.
+	w, err := b.watchValue(nodeName, property, v)
+	if err != nil {
+		return nil, err
+	}
+	return func() { w.Close() }, nil
+}
+
+// WatchValueContext is like WatchValue, but returns a Watcher whose
+// SetReadDeadline can be used to bound how long a blocked dispatch to v
+// waits for a reader, and which is automatically closed once ctx is
+// done or the Broker is Closed.
+func (b *Broker) WatchValueContext(ctx context.Context, nodeName, property string, v chan<- interface{}) (*Watcher, error) {
+	w, err := b.watchValue(nodeName, property, v)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-b.closed:
+		}
+		w.Close()
+	}()
+
+	return w, nil
+}
+
+func (b *Broker) watchValue(nodeName, property string, v chan<- interface{}) (*Watcher, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -64,10 +196,40 @@ func (b *Broker) WatchValue(nodeName, property string, v chan<- interface{}) (fu
 		return nil, fmt.Errorf("node:%d does not have property %q", n.ID, property)
 	}
 
-	w, ok := n.valueWatchers[property]
+	wm, ok := n.valueWatchers[property]
 	if !ok {
-		w = make(map[chan<- interface{}]struct{})
-		n.valueWatchers[property] = w
+		wm = make(map[chan<- interface{}]*Watcher)
+		n.valueWatchers[property] = wm
+	}
+
+	w := newWatcher(func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(wm, v)
+	})
+	wm[v] = w
+	return w, nil
+}
+
+// WatchValues is like WatchValue, but delivers a ValueEvent carrying the
+// node/property identity alongside the value, so a single channel can be
+// shared across multiple watches.
+func (b *Broker) WatchValues(nodeName, property string, v chan<- ValueEvent) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n, ok := b.nodesByName[nodeName]
+	if !ok {
+		return nil, fmt.Errorf("node %q not found", nodeName)
+	}
+	if _, ok := n.valuesByProperty[property]; !ok {
+		return nil, fmt.Errorf("node:%d does not have property %q", n.ID, property)
+	}
+
+	w, ok := n.valueEventWatchers[property]
+	if !ok {
+		w = make(map[chan<- ValueEvent]struct{})
+		n.valueEventWatchers[property] = w
 	}
 
 	w[v] = struct{}{}
@@ -79,6 +241,26 @@ func (b *Broker) WatchValue(nodeName, property string, v chan<- interface{}) (fu
 	return cancel, nil
 }
 
+// WatchAll subscribes v to every node/property update, delivering a
+// ValueEvent for each. The subscription is removed once ctx is done.
+func (b *Broker) WatchAll(ctx context.Context, v chan<- ValueEvent) error {
+	b.mu.Lock()
+	b.allWatchers[v] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-b.closed:
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.allWatchers, v)
+	}()
+
+	return nil
+}
+
 // SettAttr updates a given node's attribute. The method blocks until a response is received
 // or the context timesout, whichever comes first.
 func (b *Broker) SetAttr(ctx context.Context, nodeName, property string, value interface{}) error {
@@ -125,6 +307,8 @@ func (b *Broker) SetAttr(ctx context.Context, nodeName, property string, value i
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
+	case <-b.closed:
+		return ErrClosed
 	case err := <-wait:
 		return err
 	}
@@ -141,6 +325,8 @@ func (b *Broker) GetNodes(ctx context.Context) error {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
+	case <-b.closed:
+		return ErrClosed
 	case err := <-b.gotNodes:
 		return err
 	}
@@ -156,27 +342,82 @@ func (b *Broker) handleNodeValueUpdated(payload []byte) error {
 	if err != nil {
 		return err
 	}
-
+	// A failure to decode the previous value (e.g. none was sent) isn't
+	// fatal to delivering the current one.
+	prev, _ := update.Previous()
+
+	property := update.Property.String()
+
+	// Snapshot the subscriber sets and release b.mu before dispatching:
+	// a plain WatchValue subscriber has no Watcher to arm a deadline on,
+	// so the send to it below can block indefinitely. Holding b.mu.RLock
+	// across that send would starve every other caller blocked on
+	// b.mu.Lock() (SetAttr, WatchValue, GetNodes, ...), not just the one
+	// stuck subscriber.
 	b.mu.RLock()
-	defer b.mu.RUnlock()
-
 	n, ok := b.nodesByID[update.NodeID]
 	if !ok {
+		b.mu.RUnlock()
 		return nil
 	}
-
-	cbs, ok := n.valueWatchers[update.Property.String()]
-	if !ok {
-		return nil
+	valueWatchers := make(map[chan<- interface{}]*Watcher, len(n.valueWatchers[property]))
+	for c, w := range n.valueWatchers[property] {
+		valueWatchers[c] = w
+	}
+	eventWatchers := make(map[chan<- ValueEvent]struct{}, len(n.valueEventWatchers[property]))
+	for c := range n.valueEventWatchers[property] {
+		eventWatchers[c] = struct{}{}
 	}
+	allWatchers := make(map[chan<- ValueEvent]struct{}, len(b.allWatchers))
+	for c := range b.allWatchers {
+		allWatchers[c] = struct{}{}
+	}
+	nodeName, nodeID, store := n.Name, n.ID, b.store
+	b.mu.RUnlock()
 
-	for c := range cbs {
+	for c, w := range valueWatchers {
 		select {
 		case c <- val:
+		case <-w.cancelChan():
+		case <-b.closed:
+		}
+	}
+
+	event := ValueEvent{
+		NodeName:  nodeName,
+		NodeID:    nodeID,
+		Property:  property,
+		Value:     val,
+		Previous:  prev,
+		Timestamp: time.Now(),
+	}
+
+	for c := range eventWatchers {
+		select {
+		case c <- event:
 		default:
 		}
 	}
 
+	for c := range allWatchers {
+		select {
+		case c <- event:
+		default:
+		}
+	}
+
+	if store != nil {
+		vid := api.ValueID{
+			NodeID:       update.NodeID,
+			CommandClass: update.CommandClass,
+			Endpoint:     update.Endpoint,
+			Property:     property,
+		}
+		if err := store.SaveValue(vid, update.RawValue, event.Timestamp); err != nil {
+			return fmt.Errorf("saving value to store: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -235,25 +476,15 @@ func (b *Broker) handleGetNodesResp(payload []byte) error {
 			if _, dup := b.nodesByName[n.Name]; dup {
 				return fmt.Errorf("duplicate node name %q", n.Name)
 			}
+			b.addNodeLocked(n)
+		}
 
-			node := Node{
-				Node:             n,
-				valuesByProperty: make(map[string]*api.Value, len(n.Values)),
-				valuesByID:       make(map[string]*api.Value, len(n.Values)),
-				valueWatchers:    make(map[string]map[chan<- interface{}]struct{}),
-			}
-			for _, v := range n.Values {
-				v := v
-				// TODO: check for duplicates.
-				node.valuesByProperty[v.Property.String()] = &v
-				node.valuesByID[v.ID] = &v
-			}
-
-			if n.Name != "" {
-				b.nodesByName[n.Name] = &node
+		if b.store != nil {
+			if err := b.store.SaveNodes(resp.Result); err != nil {
+				return fmt.Errorf("saving nodes to store: %w", err)
 			}
-			b.nodesByID[n.ID] = &node
 		}
+
 		return nil
 	}()
 
@@ -265,10 +496,34 @@ func (b *Broker) handleGetNodesResp(payload []byte) error {
 	return err
 }
 
+// addNodeLocked builds a Node from an api.Node and indexes it by name and
+// ID. b.mu must be held for writing.
+func (b *Broker) addNodeLocked(n api.Node) {
+	node := &Node{
+		Node:               n,
+		valuesByProperty:   make(map[string]*api.Value, len(n.Values)),
+		valuesByID:         make(map[string]*api.Value, len(n.Values)),
+		valueWatchers:      make(map[string]map[chan<- interface{}]*Watcher),
+		valueEventWatchers: make(map[string]map[chan<- ValueEvent]struct{}),
+	}
+	for _, v := range n.Values {
+		v := v
+		// TODO: check for duplicates.
+		node.valuesByProperty[v.Property.String()] = &v
+		node.valuesByID[v.ID] = &v
+	}
+
+	if n.Name != "" {
+		b.nodesByName[n.Name] = node
+	}
+	b.nodesByID[n.ID] = node
+}
+
 type Node struct {
 	api.Node
 
-	valuesByProperty map[string]*api.Value // Value.Property -> api.Value
-	valuesByID       map[string]*api.Value // Value.ID -> api.Value
-	valueWatchers    map[string]map[chan<- interface{}]struct{}
+	valuesByProperty   map[string]*api.Value // Value.Property -> api.Value
+	valuesByID         map[string]*api.Value // Value.ID -> api.Value
+	valueWatchers      map[string]map[chan<- interface{}]*Watcher
+	valueEventWatchers map[string]map[chan<- ValueEvent]struct{}
 }
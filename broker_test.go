@@ -0,0 +1,291 @@
+package z2m
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+const getNodesRespFixture = `{
+	"success": true,
+	"result": [{
+		"id": 1,
+		"name": "light1",
+		"values": {
+			"38-0-currentValue": {
+				"id": "38-0-currentValue",
+				"nodeId": 1,
+				"commandClass": 38,
+				"endpoint": 0,
+				"property": "currentValue",
+				"type": "number",
+				"value": 1
+			}
+		}
+	}]
+}`
+
+const nodeValueUpdatedFixture = `{"data": [{
+	"id": 1,
+	"name": "light1",
+	"values": {
+		"38-0-currentValue": {
+			"id": "38-0-currentValue",
+			"nodeId": 1,
+			"commandClass": 38,
+			"endpoint": 0,
+			"property": "currentValue",
+			"type": "number",
+			"value": 2
+		}
+	}
+}, {
+	"commandClass": 38,
+	"endpoint": 0,
+	"property": "currentValue",
+	"newValue": 2
+}]}`
+
+const nodeValueUpdatedWithPrevFixture = `{"data": [{
+	"id": 1,
+	"name": "light1",
+	"values": {
+		"38-0-currentValue": {
+			"id": "38-0-currentValue",
+			"nodeId": 1,
+			"commandClass": 38,
+			"endpoint": 0,
+			"property": "currentValue",
+			"type": "number",
+			"value": 2
+		}
+	}
+}, {
+	"commandClass": 38,
+	"endpoint": 0,
+	"property": "currentValue",
+	"newValue": 2,
+	"prevValue": 1
+}]}`
+
+func TestBrokerWatchValueContextDeadlineUnblocksDispatch(t *testing.T) {
+	b := NewBroker(PublisherFunc(func(topic string, payload []byte) error { return nil }))
+
+	if err := b.handleGetNodesResp([]byte(getNodesRespFixture)); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan interface{}) // unbuffered and never read, so dispatch would otherwise block forever
+	w, err := b.WatchValueContext(context.Background(), "light1", "currentValue", ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+
+	done := make(chan error, 1)
+	go func() { done <- b.handleNodeValueUpdated([]byte(nodeValueUpdatedFixture)) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("dispatch did not unblock once the watcher's read deadline passed")
+	}
+}
+
+// TestBrokerWatchValuesDeliversValueEvent verifies WatchValues delivers a
+// ValueEvent carrying the node/property identity and the decoded current
+// and previous values.
+func TestBrokerWatchValuesDeliversValueEvent(t *testing.T) {
+	b := NewBroker(PublisherFunc(func(topic string, payload []byte) error { return nil }))
+
+	if err := b.handleGetNodesResp([]byte(getNodesRespFixture)); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan ValueEvent, 1)
+	cancel, err := b.WatchValues("light1", "currentValue", ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	if err := b.handleNodeValueUpdated([]byte(nodeValueUpdatedWithPrevFixture)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-ch:
+		if event.NodeName != "light1" || event.NodeID != 1 || event.Property != "currentValue" {
+			t.Fatalf("event = %+v, want node light1/1, property currentValue", event)
+		}
+		if event.Value != 2 {
+			t.Errorf("event.Value = %v, want 2", event.Value)
+		}
+		if event.Previous != 1 {
+			t.Errorf("event.Previous = %v, want 1", event.Previous)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("did not receive ValueEvent from WatchValues")
+	}
+}
+
+// TestBrokerWatchValuesCancel verifies the cancel function returned by
+// WatchValues stops further delivery to the channel.
+func TestBrokerWatchValuesCancel(t *testing.T) {
+	b := NewBroker(PublisherFunc(func(topic string, payload []byte) error { return nil }))
+
+	if err := b.handleGetNodesResp([]byte(getNodesRespFixture)); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan ValueEvent, 1)
+	cancel, err := b.WatchValues("light1", "currentValue", ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	if err := b.handleNodeValueUpdated([]byte(nodeValueUpdatedFixture)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-ch:
+		t.Fatalf("received %+v after cancel", event)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// TestBrokerWatchAllDeliversUnwatchedValue verifies WatchAll delivers a
+// node/property update that has no WatchValue/WatchValues subscription of
+// its own, and stops delivering once ctx is done.
+func TestBrokerWatchAllDeliversUnwatchedValue(t *testing.T) {
+	b := NewBroker(PublisherFunc(func(topic string, payload []byte) error { return nil }))
+
+	if err := b.handleGetNodesResp([]byte(getNodesRespFixture)); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan ValueEvent, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := b.WatchAll(ctx, ch); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.handleNodeValueUpdated([]byte(nodeValueUpdatedFixture)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-ch:
+		if event.NodeName != "light1" || event.Property != "currentValue" {
+			t.Fatalf("event = %+v, want node light1, property currentValue", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("did not receive ValueEvent from WatchAll")
+	}
+
+	cancel()
+	time.Sleep(20 * time.Millisecond) // let WatchAll's cancellation goroutine remove the watcher
+
+	if err := b.handleNodeValueUpdated([]byte(nodeValueUpdatedFixture)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-ch:
+		t.Fatalf("received %+v after ctx was done", event)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// TestBrokerBlockedWatchValueDoesNotStarveOtherCallers verifies that a
+// plain WatchValue subscriber with an unread channel (and therefore no
+// Watcher to arm a deadline on) blocking inside handleNodeValueUpdated
+// does not also block unrelated callers, like SetAttr, that are waiting
+// on b.mu.
+func TestBrokerBlockedWatchValueDoesNotStarveOtherCallers(t *testing.T) {
+	var b *Broker
+	pub := PublisherFunc(func(topic string, payload []byte) error {
+		if topic != "_CLIENTS/ZWAVE_GATEWAY/api/writeValue/set" {
+			return nil
+		}
+		go func() {
+			var req struct {
+				Args []json.RawMessage `json:"args"`
+			}
+			if err := json.Unmarshal(payload, &req); err != nil {
+				return
+			}
+			resp, err := json.Marshal(struct {
+				Success bool              `json:"success"`
+				Args    []json.RawMessage `json:"args"`
+			}{Success: true, Args: req.Args})
+			if err != nil {
+				return
+			}
+			b.handleWriteValueResp(resp)
+		}()
+		return nil
+	})
+	b = NewBroker(pub)
+
+	if err := b.handleGetNodesResp([]byte(getNodesRespFixture)); err != nil {
+		t.Fatal(err)
+	}
+
+	stuck := make(chan interface{}) // unbuffered and never read
+	if _, err := b.WatchValue("light1", "currentValue", stuck); err != nil {
+		t.Fatal(err)
+	}
+
+	dispatchDone := make(chan error, 1)
+	go func() { dispatchDone <- b.handleNodeValueUpdated([]byte(nodeValueUpdatedFixture)) }()
+
+	time.Sleep(20 * time.Millisecond) // let the dispatch block on the unread stuck channel
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b.SetAttr(ctx, "light1", "currentValue", 5); err != nil {
+		t.Fatalf("SetAttr blocked behind the stuck WatchValue dispatch: %v", err)
+	}
+
+	b.Close() // unstick the dispatch goroutine so it can exit
+	select {
+	case <-dispatchDone:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch did not unblock after Close")
+	}
+}
+
+func TestBrokerCloseUnblocksDispatch(t *testing.T) {
+	b := NewBroker(PublisherFunc(func(topic string, payload []byte) error { return nil }))
+
+	if err := b.handleGetNodesResp([]byte(getNodesRespFixture)); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan interface{}) // unbuffered and never read
+	if _, err := b.WatchValueContext(context.Background(), "light1", "currentValue", ch); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- b.handleNodeValueUpdated([]byte(nodeValueUpdatedFixture)) }()
+
+	time.Sleep(20 * time.Millisecond) // let the dispatch block on the unread channel
+	b.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("dispatch did not unblock once the Broker was Closed")
+	}
+}
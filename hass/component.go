@@ -0,0 +1,130 @@
+package hass
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/awilliams/z2m/api"
+)
+
+// Home Assistant MQTT component types.
+// https://www.home-assistant.io/integrations/#search/mqtt
+const (
+	componentLight        = "light"
+	componentSwitch       = "switch"
+	componentSensor       = "sensor"
+	componentBinarySensor = "binary_sensor"
+	componentClimate      = "climate"
+	componentCover        = "cover"
+	componentNumber       = "number"
+	componentSelect       = "select"
+)
+
+// mapComponent picks the Home Assistant component a Value should be
+// represented as, along with any component-specific discovery config
+// fields. ok is false if the value doesn't map to anything useful.
+func mapComponent(v api.Value) (component string, extra map[string]interface{}, ok bool) {
+	cc := v.CommandClassName
+
+	switch {
+	case strings.Contains(cc, "Binary Switch"):
+		return componentSwitch, map[string]interface{}{
+			"payload_on":  true,
+			"payload_off": false,
+		}, true
+
+	case strings.Contains(cc, "Multilevel Switch") && v.Writeable:
+		return componentLight, map[string]interface{}{
+			"payload_on":      true,
+			"payload_off":     false,
+			"brightness":      true,
+			"on_command_type": "brightness",
+		}, true
+
+	case strings.Contains(cc, "Window Covering") || strings.Contains(cc, "Barrier Operator"):
+		return componentCover, map[string]interface{}{
+			"payload_open":  "open",
+			"payload_close": "close",
+			"payload_stop":  "stop",
+		}, true
+
+	case strings.Contains(cc, "Thermostat"):
+		return componentClimate, map[string]interface{}{
+			"temperature_unit": "C",
+		}, true
+
+	case strings.Contains(cc, "Binary Sensor"), v.Type == api.TypeBool && !v.Writeable:
+		return componentBinarySensor, nil, true
+
+	case v.Type == api.TypeListString && len(v.States) > 0 && v.Writeable:
+		options := make([]string, 0, len(v.States))
+		for _, s := range v.States {
+			options = append(options, s.Text)
+		}
+		return componentSelect, map[string]interface{}{"options": options}, true
+
+	case v.Type == api.TypeNumber && v.Writeable:
+		extra = map[string]interface{}{}
+		if v.Min != 0 || v.Max != 0 {
+			extra["min"] = v.Min
+			extra["max"] = v.Max
+		}
+		return componentNumber, extra, true
+
+	case v.Type == api.TypeNumber, v.Type == api.TypeString, v.Type == api.TypeDuration, v.Type == api.TypeColor:
+		return componentSensor, nil, true
+	}
+
+	return "", nil, false
+}
+
+// decodeCommand converts the raw payload Home Assistant publishes to a
+// command topic into the Go value expected by Broker.SetAttr for the
+// given component/Value.
+func decodeCommand(component string, v api.Value, payload []byte) (interface{}, error) {
+	s := string(payload)
+
+	switch component {
+	case componentSwitch, componentLight:
+		switch s {
+		case "true", "ON", "on":
+			return true, nil
+		case "false", "OFF", "off":
+			return false, nil
+		}
+		// A brightness command for a dimmable light.
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("unrecognized %s payload %q", component, s)
+		}
+		return n, nil
+
+	case componentCover:
+		switch s {
+		case "open":
+			return 99, nil
+		case "close":
+			return 0, nil
+		case "stop":
+			return nil, fmt.Errorf("stop is not a settable value")
+		}
+		return nil, fmt.Errorf("unrecognized cover payload %q", s)
+
+	case componentNumber, componentClimate:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unrecognized %s payload %q: %w", component, s, err)
+		}
+		if f == float64(int(f)) {
+			return int(f), nil
+		}
+		return f, nil
+
+	case componentSelect:
+		return s, nil
+
+	default:
+		return s, nil
+	}
+}
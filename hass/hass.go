@@ -0,0 +1,239 @@
+// Package hass turns a *z2m.Broker into a Home Assistant MQTT Discovery
+// bridge, so zwavejs2mqtt nodes show up in Home Assistant without needing
+// zwavejs2mqtt's own Hass integration enabled.
+//
+// https://www.home-assistant.io/docs/mqtt/discovery/
+package hass
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"sync"
+
+	"github.com/awilliams/z2m"
+	"github.com/awilliams/z2m/api"
+)
+
+const (
+	// DefaultDiscoveryPrefix is the topic prefix Home Assistant listens on
+	// for discovery config payloads, unless configured otherwise.
+	DefaultDiscoveryPrefix = "homeassistant"
+
+	payloadOnline  = "online"
+	payloadOffline = "offline"
+)
+
+// Bridge publishes Home Assistant MQTT Discovery config payloads for the
+// nodes/values known to a z2m.Broker, and keeps their state topics and
+// availability up to date.
+type Bridge struct {
+	broker *z2m.Broker
+	pub    z2m.Publisher
+
+	discoveryPrefix string
+	baseTopic       string
+	availTopic      string
+
+	mu          sync.RWMutex
+	commands    map[string]func([]byte) error // command topic -> handler
+	watchCancel context.CancelFunc            // stops the previous Publish call's state watchers, if any
+}
+
+// NewBridge returns a Bridge that publishes discovery configs and state
+// through publisher, using discoveryPrefix (e.g. "homeassistant") for
+// config topics and baseTopic (e.g. "z2m") as the root for this bridge's
+// own state/command topics.
+func NewBridge(broker *z2m.Broker, publisher z2m.Publisher, discoveryPrefix, baseTopic string) *Bridge {
+	if discoveryPrefix == "" {
+		discoveryPrefix = DefaultDiscoveryPrefix
+	}
+	return &Bridge{
+		broker:          broker,
+		pub:             publisher,
+		discoveryPrefix: discoveryPrefix,
+		baseTopic:       baseTopic,
+		availTopic:      path.Join(baseTopic, "bridge", "state"),
+		commands:        make(map[string]func([]byte) error),
+	}
+}
+
+// Subscriptions returns the MQTT topics this Bridge needs to receive
+// commands published by Home Assistant, mirroring Broker.Subscriptions.
+// Publish must be called first so the topics are known.
+func (b *Bridge) Subscriptions() map[string]func(payload []byte) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	subs := make(map[string]func([]byte) error, len(b.commands))
+	for topic, h := range b.commands {
+		subs[topic] = h
+	}
+	return subs
+}
+
+// Publish generates and publishes a Home Assistant discovery config for
+// every api.Value on every node known to the Broker, and starts
+// republishing state on subsequent value updates. It should be called
+// after z2m.Broker.GetNodes has completed.
+//
+// Publish is safe to call more than once, e.g. to republish discovery
+// configs after an MQTT reconnect: each call tears down the state
+// watchers and command handlers registered by the previous call before
+// republishing, rather than stacking duplicates.
+func (b *Bridge) Publish() error {
+	b.mu.Lock()
+	if b.watchCancel != nil {
+		b.watchCancel()
+	}
+	watchCtx, cancel := context.WithCancel(context.Background())
+	b.watchCancel = cancel
+	for topic := range b.commands {
+		delete(b.commands, topic)
+	}
+	b.mu.Unlock()
+
+	for _, n := range b.broker.Nodes() {
+		for _, v := range n.Values {
+			if err := b.publishValue(watchCtx, n, v); err != nil {
+				return fmt.Errorf("node %q value %q: %w", n.Name, v.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Online announces the bridge (and therefore all of its entities) as
+// available. Call once MQTT is connected and Publish has run.
+func (b *Bridge) Online() error {
+	return b.pub.Publish(b.availTopic, []byte(payloadOnline))
+}
+
+// Offline announces the bridge as unavailable, e.g. on a clean shutdown.
+func (b *Bridge) Offline() error {
+	return b.pub.Publish(b.availTopic, []byte(payloadOffline))
+}
+
+func (b *Bridge) publishValue(ctx context.Context, n *z2m.Node, v api.Value) error {
+	component, extra, ok := mapComponent(v)
+	if !ok {
+		// No sensible Home Assistant component for this value; skip it.
+		return nil
+	}
+
+	nodeID := strconv.Itoa(n.ID)
+	stateTopic := path.Join(b.baseTopic, nodeID, v.ID, "state")
+	cfg := discoveryConfig{
+		Name:              fmt.Sprintf("%s %s", n.Name, v.Label),
+		UniqueID:          fmt.Sprintf("z2m_%d_%s", n.ID, v.ID),
+		StateTopic:        stateTopic,
+		AvailabilityTopic: b.availTopic,
+		Device: device{
+			Identifiers:  []string{fmt.Sprintf("z2m_%d", n.ID)},
+			Name:         n.Name,
+			Manufacturer: n.Manufacturer,
+			Model:        n.ProductLabel,
+		},
+		extra: extra,
+	}
+
+	if v.Writeable {
+		commandTopic := path.Join(b.baseTopic, nodeID, v.ID, "set")
+		cfg.CommandTopic = commandTopic
+
+		property := v.Property.String()
+		b.mu.Lock()
+		b.commands[commandTopic] = func(payload []byte) error {
+			val, err := decodeCommand(component, v, payload)
+			if err != nil {
+				return fmt.Errorf("decode command for %q: %w", n.Name, err)
+			}
+			// Each invocation gets its own context rather than ctx, which
+			// is tied to the Publish call that registered this handler
+			// and is cancelled by the next Publish call.
+			return b.broker.SetAttr(context.Background(), n.Name, property, val)
+		}
+		b.mu.Unlock()
+	}
+
+	payload, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	configTopic := path.Join(b.discoveryPrefix, component, nodeID, v.ID, "config")
+	if err := b.pub.Publish(configTopic, payload); err != nil {
+		return err
+	}
+
+	ch := make(chan interface{}, 1)
+	if _, err := b.broker.WatchValueContext(ctx, n.Name, v.Property.String(), ch); err != nil {
+		return err
+	}
+
+	go b.watchState(ctx, stateTopic, ch)
+
+	return nil
+}
+
+func (b *Bridge) watchState(ctx context.Context, stateTopic string, ch <-chan interface{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case val := <-ch:
+			payload, err := json.Marshal(val)
+			if err != nil {
+				continue
+			}
+			b.pub.Publish(stateTopic, payload)
+		}
+	}
+}
+
+// device describes the physical node an entity belongs to, so Home
+// Assistant groups its values under a single device.
+type device struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	Model        string   `json:"model,omitempty"`
+}
+
+// discoveryConfig is the JSON payload published to
+// <discoveryPrefix>/<component>/<node_id>/<value_id>/config.
+//
+// Only the fields common to every component are named explicitly; the
+// remaining component-specific fields (device_class, unit_of_measurement,
+// min/max, payload_on/off, options, ...) are merged in from extra.
+type discoveryConfig struct {
+	Name              string `json:"name"`
+	UniqueID          string `json:"unique_id"`
+	StateTopic        string `json:"state_topic,omitempty"`
+	CommandTopic      string `json:"command_topic,omitempty"`
+	AvailabilityTopic string `json:"availability_topic,omitempty"`
+	Device            device `json:"device"`
+
+	extra map[string]interface{}
+}
+
+func (c discoveryConfig) MarshalJSON() ([]byte, error) {
+	out := map[string]interface{}{
+		"name":               c.Name,
+		"unique_id":          c.UniqueID,
+		"availability_topic": c.AvailabilityTopic,
+		"device":             c.Device,
+	}
+	if c.StateTopic != "" {
+		out["state_topic"] = c.StateTopic
+	}
+	if c.CommandTopic != "" {
+		out["command_topic"] = c.CommandTopic
+	}
+	for k, v := range c.extra {
+		out[k] = v
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,182 @@
+package hass
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/awilliams/z2m"
+	"github.com/awilliams/z2m/api"
+)
+
+const getNodesRespFixture = `{
+	"success": true,
+	"result": [{
+		"id": 4,
+		"name": "dimmer1",
+		"values": {
+			"38-0-targetValue": {
+				"id": "38-0-targetValue",
+				"nodeId": 4,
+				"commandClass": 38,
+				"commandClassName": "Multilevel Switch",
+				"endpoint": 0,
+				"property": "targetValue",
+				"type": "number",
+				"readable": true,
+				"writeable": true
+			}
+		}
+	}]
+}`
+
+// fakePublisher records every published topic/payload, and optionally
+// reacts to specific topics (e.g. to fake a device's writeValue response).
+type fakePublisher struct {
+	mu       sync.Mutex
+	counts   map[string]int
+	reactors map[string]func(payload []byte) error
+}
+
+func newFakePublisher() *fakePublisher {
+	return &fakePublisher{
+		counts:   make(map[string]int),
+		reactors: make(map[string]func(payload []byte) error),
+	}
+}
+
+func (p *fakePublisher) Publish(topic string, payload []byte) error {
+	p.mu.Lock()
+	p.counts[topic]++
+	reactor := p.reactors[topic]
+	p.mu.Unlock()
+
+	if reactor != nil {
+		return reactor(payload)
+	}
+	return nil
+}
+
+func (p *fakePublisher) count(topic string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.counts[topic]
+}
+
+func newTestBridge(t *testing.T, pub *fakePublisher) (*z2m.Broker, *Bridge) {
+	t.Helper()
+
+	broker := z2m.NewBroker(pub)
+	subs := broker.Subscriptions("")
+	handler, ok := subs[api.TopicGetNodesResp]
+	if !ok {
+		t.Fatal("broker missing getNodes response handler")
+	}
+	if err := handler([]byte(getNodesRespFixture)); err != nil {
+		t.Fatal(err)
+	}
+
+	return broker, NewBridge(broker, pub, "", "z2m")
+}
+
+// TestBridgePublishIdempotent verifies a second Publish call tears down
+// the first call's state watchers instead of stacking a duplicate one,
+// so a single value update is only republished once.
+func TestBridgePublishIdempotent(t *testing.T) {
+	pub := newFakePublisher()
+	broker, bridge := newTestBridge(t, pub)
+
+	if err := bridge.Publish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bridge.Publish(); err != nil {
+		t.Fatal(err)
+	}
+
+	updateHandler := broker.Subscriptions("")[api.TopicNodeValueUpdateEvent]
+	update := []byte(`{"data": [{
+		"id": 4,
+		"name": "dimmer1",
+		"values": {
+			"38-0-targetValue": {
+				"id": "38-0-targetValue",
+				"nodeId": 4,
+				"commandClass": 38,
+				"endpoint": 0,
+				"property": "targetValue",
+				"type": "number",
+				"writeable": true,
+				"value": 42
+			}
+		}
+	}, {
+		"commandClass": 38,
+		"endpoint": 0,
+		"property": "targetValue",
+		"newValue": 42
+	}]}`)
+	if err := updateHandler(update); err != nil {
+		t.Fatal(err)
+	}
+
+	// Give the (single, surviving) watchState goroutine time to publish.
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if pub.count("z2m/4/38-0-targetValue/state") > 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if got := pub.count("z2m/4/38-0-targetValue/state"); got != 1 {
+		t.Fatalf("state topic published %d times after 2 Publish calls, want 1", got)
+	}
+}
+
+// TestBridgeCommandHandlerUsesFreshContext verifies the registered command
+// handler still works well after Publish has returned.
+func TestBridgeCommandHandlerUsesFreshContext(t *testing.T) {
+	pub := newFakePublisher()
+	broker, bridge := newTestBridge(t, pub)
+
+	writeValueRespHandler, ok := broker.Subscriptions("")[api.TopicWriteValueResp]
+	if !ok {
+		t.Fatal("broker missing writeValue response handler")
+	}
+
+	pub.mu.Lock()
+	pub.reactors["_CLIENTS/ZWAVE_GATEWAY/api/writeValue/set"] = func(payload []byte) error {
+		var req struct {
+			Args []json.RawMessage `json:"args"`
+		}
+		if err := json.Unmarshal(payload, &req); err != nil {
+			t.Error(err)
+			return nil
+		}
+		resp, err := json.Marshal(struct {
+			Success bool              `json:"success"`
+			Args    []json.RawMessage `json:"args"`
+		}{Success: true, Args: []json.RawMessage{req.Args[0], req.Args[1]}})
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		return writeValueRespHandler(resp)
+	}
+	pub.mu.Unlock()
+
+	if err := bridge.Publish(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond) // simulate a command arriving well after Publish returned
+
+	handler, ok := bridge.Subscriptions()["z2m/4/38-0-targetValue/set"]
+	if !ok {
+		t.Fatal("bridge missing command handler for targetValue")
+	}
+	if err := handler([]byte("42")); err != nil {
+		t.Fatalf("command handler failed: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/awilliams/z2m/api"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltBucket = []byte("z2m")
+	boltKey    = []byte("nodes")
+)
+
+// Bolt is a z2m.Store backed by a bbolt database file. Like File, it
+// keeps the full node snapshot as a single encoded value, which keeps
+// the implementation simple at the cost of rewriting the whole snapshot
+// on every SaveValue.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// NewBolt opens (creating if necessary) a bbolt database at path for use
+// as a Store.
+func NewBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("store: opening %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Bolt{db: db}, nil
+}
+
+// Close releases the underlying bbolt database's file lock.
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+func (b *Bolt) SaveNodes(nodes []api.Node) error {
+	return b.put(nodes)
+}
+
+func (b *Bolt) LoadNodes() ([]api.Node, error) {
+	var nodes []api.Node
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get(boltKey)
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &nodes)
+	})
+	return nodes, err
+}
+
+func (b *Bolt) SaveValue(id api.ValueID, raw json.RawMessage, t time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+
+		var nodes []api.Node
+		if v := bucket.Get(boltKey); v != nil {
+			if err := json.Unmarshal(v, &nodes); err != nil {
+				return err
+			}
+		}
+
+		if err := saveValue(nodes, id, raw, t); err != nil {
+			return err
+		}
+
+		encoded, err := json.Marshal(nodes)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(boltKey, encoded)
+	})
+}
+
+func (b *Bolt) put(nodes []api.Node) error {
+	encoded, err := json.Marshal(nodes)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(boltKey, encoded)
+	})
+}
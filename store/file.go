@@ -0,0 +1,86 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/awilliams/z2m/api"
+)
+
+// File is a z2m.Store backed by a single JSON file. SaveNodes/SaveValue
+// write the full snapshot back to disk, so it's best suited to the
+// relatively low update rate of a Z-Wave network rather than high
+// frequency writes.
+type File struct {
+	path string
+
+	mu    sync.Mutex
+	nodes []api.Node
+}
+
+// NewFile returns a File store that reads/writes the node snapshot at
+// path. The file is not created until the first SaveNodes or SaveValue.
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) SaveNodes(nodes []api.Node) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nodes = nodes
+	return f.flushLocked()
+}
+
+func (f *File) LoadNodes() ([]api.Node, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fd, err := os.Open(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var nodes []api.Node
+	if err := json.NewDecoder(fd).Decode(&nodes); err != nil {
+		return nil, fmt.Errorf("store: decoding %s: %w", f.path, err)
+	}
+	f.nodes = nodes
+	return nodes, nil
+}
+
+func (f *File) SaveValue(id api.ValueID, raw json.RawMessage, t time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := saveValue(f.nodes, id, raw, t); err != nil {
+		return err
+	}
+	return f.flushLocked()
+}
+
+// flushLocked writes f.nodes to f.path, via a temp file and rename so a
+// crash mid-write can't leave behind a truncated snapshot. f.mu must be
+// held.
+func (f *File) flushLocked() error {
+	tmp := f.path + ".tmp"
+	fd, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(fd).Encode(f.nodes); err != nil {
+		fd.Close()
+		return fmt.Errorf("store: encoding %s: %w", f.path, err)
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
@@ -0,0 +1,63 @@
+// Package store provides z2m.Store implementations for persisting node
+// and value state across process restarts.
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/awilliams/z2m/api"
+)
+
+// Memory is an in-memory z2m.Store. It's mainly useful for tests, since
+// its state doesn't survive a process restart.
+type Memory struct {
+	mu    sync.Mutex
+	nodes []api.Node
+}
+
+// NewMemory returns an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) SaveNodes(nodes []api.Node) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodes = nodes
+	return nil
+}
+
+func (m *Memory) LoadNodes() ([]api.Node, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.nodes, nil
+}
+
+func (m *Memory) SaveValue(id api.ValueID, raw json.RawMessage, t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return saveValue(m.nodes, id, raw, t)
+}
+
+// saveValue locates the api.Value addressed by id within nodes and
+// overwrites its raw value and last-update timestamp in place.
+func saveValue(nodes []api.Node, id api.ValueID, raw json.RawMessage, t time.Time) error {
+	for i := range nodes {
+		if nodes[i].ID != id.NodeID {
+			continue
+		}
+		key := fmt.Sprintf("%d-%d-%s", id.CommandClass, id.Endpoint, id.Property)
+		v, ok := nodes[i].Values[key]
+		if !ok {
+			return fmt.Errorf("store: node %d has no value %q", id.NodeID, key)
+		}
+		v.RawValue = raw
+		v.LastUpdate = t.Unix()
+		nodes[i].Values[key] = v
+		return nil
+	}
+	return fmt.Errorf("store: node %d not found", id.NodeID)
+}
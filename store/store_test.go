@@ -0,0 +1,149 @@
+package store
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/awilliams/z2m/api"
+)
+
+const sampleNodesJSON = `[{
+	"id": 1,
+	"name": "light1",
+	"values": {
+		"38-0-currentValue": {
+			"id": "38-0-currentValue",
+			"nodeId": 1,
+			"commandClass": 38,
+			"endpoint": 0,
+			"property": "currentValue",
+			"type": "number",
+			"value": 1
+		}
+	}
+}]`
+
+func sampleNodes(t *testing.T) []api.Node {
+	t.Helper()
+	var nodes []api.Node
+	if err := json.Unmarshal([]byte(sampleNodesJSON), &nodes); err != nil {
+		t.Fatal(err)
+	}
+	return nodes
+}
+
+// testStore is the subset of z2m.Store exercised by testSaveLoadValue. It
+// lets the same test body run against Memory, File, and Bolt without this
+// package importing z2m.
+type testStore interface {
+	SaveNodes(nodes []api.Node) error
+	LoadNodes() ([]api.Node, error)
+	SaveValue(id api.ValueID, raw json.RawMessage, t time.Time) error
+}
+
+func testSaveLoadValue(t *testing.T, s testStore) {
+	t.Helper()
+
+	if err := s.SaveNodes(sampleNodes(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.LoadNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != 1 {
+		t.Fatalf("LoadNodes() = %+v, want 1 node with ID 1", got)
+	}
+
+	ts := time.Unix(1700000000, 0)
+	vid := api.ValueID{NodeID: 1, CommandClass: 38, Endpoint: 0, Property: "currentValue"}
+	if err := s.SaveValue(vid, json.RawMessage(`42`), ts); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = s.LoadNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, ok := got[0].Values["38-0-currentValue"]
+	if !ok {
+		t.Fatal("LoadNodes() result missing value 38-0-currentValue")
+	}
+	if string(v.RawValue) != "42" {
+		t.Errorf("RawValue = %s, want 42", v.RawValue)
+	}
+	if v.LastUpdate != ts.Unix() {
+		t.Errorf("LastUpdate = %d, want %d", v.LastUpdate, ts.Unix())
+	}
+}
+
+func TestMemorySaveLoadValue(t *testing.T) {
+	testSaveLoadValue(t, NewMemory())
+}
+
+func TestFileSaveLoadValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.json")
+	testSaveLoadValue(t, NewFile(path))
+}
+
+func TestFileSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.json")
+
+	f := NewFile(path)
+	if err := f.SaveNodes(sampleNodes(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh File pointed at the same path simulates a process restart.
+	restarted := NewFile(path)
+	got, err := restarted.LoadNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != 1 {
+		t.Fatalf("LoadNodes() after restart = %+v, want 1 node with ID 1", got)
+	}
+}
+
+func TestBoltSaveLoadValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "z2m.db")
+	b, err := NewBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	testSaveLoadValue(t, b)
+}
+
+func TestBoltSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "z2m.db")
+
+	b, err := NewBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveNodes(sampleNodes(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := NewBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+
+	got, err := restarted.LoadNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != 1 {
+		t.Fatalf("LoadNodes() after restart = %+v, want 1 node with ID 1", got)
+	}
+}
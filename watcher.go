@@ -0,0 +1,80 @@
+package z2m
+
+import (
+	"sync"
+	"time"
+)
+
+// Watcher is returned by WatchValueContext. It wraps the subscription
+// registered with a Broker and, like a net.Conn, supports an optional read
+// deadline so a consumer that stops reading doesn't block the Broker's
+// dispatch loop forever.
+type Watcher struct {
+	remove func()
+
+	mu      sync.Mutex
+	cancel  chan struct{} // closed once the deadline passes
+	timer   *time.Timer
+	removed bool
+}
+
+func newWatcher(remove func()) *Watcher {
+	return &Watcher{remove: remove, cancel: make(chan struct{})}
+}
+
+// SetReadDeadline arms a deadline after which the Watcher's internal
+// cancel channel is closed. The Broker's dispatch loop selects on this
+// channel, so a missed read past the deadline becomes a clean timeout
+// rather than a silent drop. A zero Time disarms any deadline, and a past
+// Time closes the channel immediately.
+func (w *Watcher) SetReadDeadline(t time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	// Always start from a fresh channel rather than conditionally
+	// reusing the old one. Timer.Stop()'s return value can't be trusted
+	// to tell us whether w.cancel is still open: a prior call may have
+	// closed it directly (a past deadline) with w.timer left nil, so
+	// relying on it risks closing an already-closed channel. Each
+	// AfterFunc below only ever closes the channel instance it captured
+	// at creation time, so replacing w.cancel here is always safe.
+	w.cancel = make(chan struct{})
+
+	switch {
+	case t.IsZero():
+		w.timer = nil
+	case t.Before(time.Now()):
+		close(w.cancel)
+		w.timer = nil
+	default:
+		ch := w.cancel
+		w.timer = time.AfterFunc(time.Until(t), func() { close(ch) })
+	}
+}
+
+// Close stops the Watcher, unsubscribing it from the Broker it was
+// created from. It is safe to call more than once.
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	already := w.removed
+	w.removed = true
+	w.mu.Unlock()
+
+	if !already {
+		w.remove()
+	}
+	return nil
+}
+
+func (w *Watcher) cancelChan() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.cancel
+}
@@ -0,0 +1,65 @@
+package z2m
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatcherSetReadDeadlinePastTwiceDoesNotPanic(t *testing.T) {
+	w := newWatcher(func() {})
+
+	w.SetReadDeadline(time.Now().Add(-time.Hour))
+	w.SetReadDeadline(time.Now().Add(-time.Hour)) // must not panic closing an already-closed channel
+
+	select {
+	case <-w.cancelChan():
+	default:
+		t.Fatal("cancelChan should be closed after a past deadline")
+	}
+}
+
+func TestWatcherSetReadDeadlineFires(t *testing.T) {
+	w := newWatcher(func() {})
+	w.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+
+	select {
+	case <-w.cancelChan():
+		t.Fatal("cancelChan closed before the deadline passed")
+	default:
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	select {
+	case <-w.cancelChan():
+	default:
+		t.Fatal("cancelChan should be closed once the deadline passes")
+	}
+}
+
+func TestWatcherSetReadDeadlineZeroDisarms(t *testing.T) {
+	w := newWatcher(func() {})
+	w.SetReadDeadline(time.Now().Add(-time.Hour))
+	w.SetReadDeadline(time.Time{})
+
+	select {
+	case <-w.cancelChan():
+		t.Fatal("cancelChan should be open once the deadline is disarmed")
+	default:
+	}
+}
+
+func TestWatcherClose(t *testing.T) {
+	var removed bool
+	w := newWatcher(func() { removed = true })
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !removed {
+		t.Fatal("Close should invoke remove")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("second Close should be a no-op, got err: %v", err)
+	}
+}